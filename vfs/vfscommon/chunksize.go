@@ -0,0 +1,66 @@
+package vfscommon
+
+import "time"
+
+// ChunkSizeController adaptively picks the size of the next chunk to
+// read from a single open file, in place of ChunkSizeLimit's static
+// doubling. It aims to keep each chunk's read time close to
+// Options.ChunkTargetTime: slow links converge on small chunks (low
+// time-to-first-byte), fast links converge on large chunks (high
+// throughput), and stalls or errors cause it to back off.
+type ChunkSizeController struct {
+	min        int64
+	max        int64
+	targetTime time.Duration
+}
+
+// NewChunkSizeController returns a ChunkSizeController bounded by
+// opt.ChunkSizeMin/ChunkSizeMax and targeting opt.ChunkTargetTime.
+func NewChunkSizeController(opt *Options) *ChunkSizeController {
+	c := &ChunkSizeController{
+		min:        int64(opt.ChunkSizeMin),
+		max:        int64(opt.ChunkSizeMax),
+		targetTime: opt.ChunkTargetTime,
+	}
+	if c.targetTime <= 0 {
+		c.targetTime = 2 * time.Second
+	}
+	if c.max < c.min {
+		c.max = c.min
+	}
+	return c
+}
+
+// Next returns the chunk size to use for the next read, given the size
+// of the previous chunk and how long it took to read it. An elapsed of
+// 0 or less signals a stall or error, and the controller halves the
+// chunk size rather than trying to ramp up.
+func (c *ChunkSizeController) Next(prevSize int64, elapsed time.Duration) int64 {
+	if prevSize <= 0 {
+		prevSize = c.min
+	}
+	if elapsed <= 0 {
+		return c.clamp(prevSize / 2)
+	}
+	// Scale prevSize by how far actual time was from the target: faster
+	// than target ramps up, slower than target ramps down. Clamp in
+	// float space first: a read that completes in a tiny fraction of
+	// targetTime drives scale very high, and prevSize*scale can overflow
+	// int64 (even wrapping negative) before clamp ever sees it.
+	scale := float64(c.targetTime) / float64(elapsed)
+	next := float64(prevSize) * scale
+	if next > float64(c.max) {
+		return c.max
+	}
+	return c.clamp(int64(next))
+}
+
+func (c *ChunkSizeController) clamp(size int64) int64 {
+	if size < c.min {
+		return c.min
+	}
+	if size > c.max {
+		return c.max
+	}
+	return size
+}
@@ -0,0 +1,153 @@
+// Package cryptcache provides at-rest encryption for the VFS disk
+// cache. Every cached file gets its own AES-256-GCM key, derived via
+// scrypt from the key file's contents plus that file's random salt, so
+// two files share a key, and so a block-index nonce, only if their
+// salts are identical. Cache chunks are encrypted in plaintext blocks
+// aligned to the VFS ChunkSize; each encrypted block is BlockOverhead
+// bytes larger on disk than the plaintext block it came from, since GCM
+// appends an authentication tag.
+package cryptcache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported values for Options.CacheEncryptCipher
+const (
+	CipherAES256GCM = "aes-256-gcm"
+)
+
+const (
+	saltSize = 32 // bytes of random salt stored per file in the xattr sidecar
+	keySize  = 32 // AES-256
+)
+
+// Cipher holds the validated passphrase used to derive a separate
+// per-file key for every cached file. It does not itself encrypt
+// anything; call ForFile to get a FileCipher for a given file's salt.
+type Cipher struct {
+	passphrase []byte
+	blockSize  int64
+}
+
+// New reads and validates the contents of keyFile (it must be readable
+// and not empty) and returns a Cipher ready to derive per-file keys for
+// blocks of blockSize bytes. cipherName must be CipherAES256GCM, the
+// only cipher currently supported, or empty, which selects it.
+func New(keyFile, cipherName string, blockSize int64) (*Cipher, error) {
+	if cipherName != "" && cipherName != CipherAES256GCM {
+		return nil, fmt.Errorf("cryptcache: unsupported cipher %q", cipherName)
+	}
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("cryptcache: invalid block size %d", blockSize)
+	}
+	passphrase, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cryptcache: failed to read key file: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("cryptcache: key file %q is empty", keyFile)
+	}
+	return &Cipher{passphrase: passphrase, blockSize: blockSize}, nil
+}
+
+// BlockSize returns the plaintext block size blocks are aligned to, as
+// passed to New.
+func (c *Cipher) BlockSize() int64 {
+	return c.blockSize
+}
+
+// NewSalt returns a fresh random per-file salt. It must be generated
+// once per cached file, stored in that file's xattr sidecar, and passed
+// to ForFile every time the file is opened again.
+func (c *Cipher) NewSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("cryptcache: failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// ForFile derives this file's key from the key file passphrase and its
+// salt via scrypt, and returns a FileCipher that encrypts/decrypts its
+// blocks. Every file gets an independent key, so a block-index nonce
+// can never be reused under the same key even if two files' salts
+// happen to share a prefix.
+func (c *Cipher) ForFile(salt []byte) (*FileCipher, error) {
+	key, err := scrypt.Key(c.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("cryptcache: failed to derive per-file key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cryptcache: failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cryptcache: failed to create AEAD: %w", err)
+	}
+	return &FileCipher{aead: aead, blockSize: c.blockSize}, nil
+}
+
+// FileCipher encrypts and decrypts the blocks of a single cached file
+// under a key unique to that file. Nonces are just the big-endian
+// block index: since the key is never shared with another file, two
+// blocks can only collide on a nonce by reusing the same index under
+// the same FileCipher. Encrypt does not prevent that: encrypting a
+// block index a second time reuses its nonce.
+type FileCipher struct {
+	aead      cipher.AEAD
+	blockSize int64
+}
+
+// BlockSize returns the plaintext block size blocks are aligned to.
+func (f *FileCipher) BlockSize() int64 {
+	return f.blockSize
+}
+
+// BlockOverhead is how many bytes larger an encrypted block is than the
+// plaintext block it was created from: the GCM authentication tag.
+// Callers that lay out the on-disk cache file must reserve
+// BlockSize()+BlockOverhead bytes per block, not BlockSize() bytes.
+func (f *FileCipher) BlockOverhead() int {
+	return f.aead.Overhead()
+}
+
+// Encrypt encrypts one block of plaintext, read from offset
+// blockIndex*BlockSize() in the cached file. plaintext must be at most
+// BlockSize() bytes (the last block of a file may be shorter); the
+// returned ciphertext is len(plaintext)+BlockOverhead() bytes.
+func (f *FileCipher) Encrypt(blockIndex int64, plaintext []byte) ([]byte, error) {
+	if int64(len(plaintext)) > f.blockSize {
+		return nil, fmt.Errorf("cryptcache: plaintext block %d is %d bytes, larger than the %d byte block size", blockIndex, len(plaintext), f.blockSize)
+	}
+	return f.aead.Seal(nil, f.nonce(blockIndex), plaintext, nil), nil
+}
+
+// Decrypt reverses Encrypt.
+func (f *FileCipher) Decrypt(blockIndex int64, ciphertext []byte) ([]byte, error) {
+	plaintext, err := f.aead.Open(nil, f.nonce(blockIndex), ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cryptcache: failed to decrypt block %d: %w", blockIndex, err)
+	}
+	return plaintext, nil
+}
+
+// nonce turns a block index into this file's nonce for that block: the
+// index right-aligned in big-endian order, zero-padded on the left.
+func (f *FileCipher) nonce(blockIndex int64) []byte {
+	nonce := make([]byte, f.aead.NonceSize())
+	counterSize := 8
+	if counterSize > len(nonce) {
+		counterSize = len(nonce)
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-counterSize:], uint64(blockIndex))
+	return nonce
+}
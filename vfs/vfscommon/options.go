@@ -8,38 +8,61 @@ import (
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/vfs/vfscommon/cryptcache"
 )
 
 // Options is options for creating the vfs
 type Options struct {
-	NoSeek             bool          // don't allow seeking if set
-	NoChecksum         bool          // don't check checksums if set
-	ReadOnly           bool          // if set VFS is read only
-	NoModTime          bool          // don't read mod times for files
-	DirCacheTime       time.Duration // how long to consider directory listing cache valid
-	PollInterval       time.Duration
-	Umask              int
-	UID                uint32
-	GID                uint32
-	DirPerms           os.FileMode
-	FilePerms          os.FileMode
-	ChunkSize          fs.SizeSuffix // if > 0 read files in chunks
-	ChunkSizeLimit     fs.SizeSuffix // if > ChunkSize double the chunk size after each chunk until reached
-	CacheMode          CacheMode
-	CacheMaxAge        time.Duration
-	CacheMaxSize       fs.SizeSuffix
-	CacheMinFreeSpace  fs.SizeSuffix
-	CachePollInterval  time.Duration
-	CaseInsensitive    bool
-	WriteWait          time.Duration // time to wait for in-sequence write
-	ReadWait           time.Duration // time to wait for in-sequence read
-	WriteBack          time.Duration // time to wait before writing back dirty files
-	ReadAhead          fs.SizeSuffix // bytes to read ahead in cache mode "full"
-	UsedIsSize         bool          // if true, use the `rclone size` algorithm for Used size
-	FastFingerprint    bool          // if set use fast fingerprints
-	DiskSpaceTotalSize fs.SizeSuffix
-	VfsUploadExclude   []string
-	VfsExcludeRegex    []*regexp.Regexp
+	NoSeek              bool          // don't allow seeking if set
+	NoChecksum          bool          // don't check checksums if set
+	ReadOnly            bool          // if set VFS is read only
+	NoModTime           bool          // don't read mod times for files
+	DirCacheTime        time.Duration // how long to consider directory listing cache valid
+	PollInterval        time.Duration
+	Umask               int
+	UID                 uint32
+	GID                 uint32
+	DirPerms            os.FileMode
+	FilePerms           os.FileMode
+	ChunkSize           fs.SizeSuffix // if > 0 read files in chunks
+	ChunkSizeLimit      fs.SizeSuffix // if > ChunkSize double the chunk size after each chunk until reached
+	ChunkAdaptive       bool          // if set, size chunks with a ChunkSizeController instead of ChunkSizeLimit doubling
+	ChunkSizeMin        fs.SizeSuffix // smallest chunk size the adaptive controller will pick
+	ChunkSizeMax        fs.SizeSuffix // largest chunk size the adaptive controller will pick
+	ChunkTargetTime     time.Duration // target time for reading a single chunk when ChunkAdaptive is set
+	CacheMode           CacheMode
+	CacheMaxAge         time.Duration
+	CacheMaxSize        fs.SizeSuffix
+	CacheMinFreeSpace   fs.SizeSuffix
+	CachePollInterval   time.Duration
+	CaseInsensitive     bool
+	WriteWait           time.Duration // time to wait for in-sequence write
+	ReadWait            time.Duration // time to wait for in-sequence read
+	WriteBack           time.Duration // time to wait before writing back dirty files
+	ReadAhead           fs.SizeSuffix // bytes to read ahead in cache mode "full"
+	UsedIsSize          bool          // if true, use the `rclone size` algorithm for Used size
+	FastFingerprint     bool          // if set use fast fingerprints
+	DiskSpaceTotalSize  fs.SizeSuffix
+	VfsUploadExclude    []string
+	VfsExcludeRegex     []*regexp.Regexp
+	VfsCacheInclude     []string      // glob patterns: only cache matching files if non-empty
+	VfsCacheExclude     []string      // glob patterns: never cache matching files
+	VfsCacheMinSize     fs.SizeSuffix // only cache files >= this size
+	VfsCacheMaxSize     fs.SizeSuffix // only cache files <= this size
+	VfsCacheMinAge      time.Duration // only cache files modified more than this long ago
+	VfsCacheMaxAge      time.Duration // only cache files modified less than this long ago
+	VfsProfilesFile     string        // path to a YAML/JSON file of per-remote Options overrides
+	CacheEncrypt        bool          // if set, encrypt the VFS disk cache at rest
+	CacheEncryptKeyFile string        // file whose contents are used to derive the cache encryption key
+	CacheEncryptCipher  string        // cipher to use, defaults to cryptcache.CipherAES256GCM
+	MetricsEnabled      bool          // if set, publish Prometheus metrics for this VFS
+	MetricsName         string        // label used to distinguish this VFS's metrics from others in the same process
+
+	cacheFilter     *filter.Filter       // compiled from the VfsCache* include/exclude/size/age settings
+	profiles        []VfsProfile         // parsed from VfsProfilesFile
+	cacheCipher     *cryptcache.Cipher   // set up from CacheEncrypt* if CacheEncrypt is set
+	metrics         *Metrics             // set up from MetricsEnabled if set
+	chunkController *ChunkSizeController // set up from ChunkSizeMin/Max/ChunkTargetTime if ChunkAdaptive is set
 }
 
 // DefaultOpt is the default values uses for Opt
@@ -69,6 +92,13 @@ var DefaultOpt = Options{
 	ReadAhead:          0 * fs.Mebi,
 	UsedIsSize:         false,
 	DiskSpaceTotalSize: -1,
+	VfsCacheMinSize:    0,
+	VfsCacheMaxSize:    -1,
+	ChunkAdaptive:      false,
+	ChunkSizeMin:       1 * fs.Mebi,
+	ChunkSizeMax:       128 * fs.Mebi,
+	ChunkTargetTime:    2 * time.Second,
+	CacheEncryptCipher: cryptcache.CipherAES256GCM,
 }
 
 func initializeExclusionPatterns(opt *Options) {
@@ -82,6 +112,112 @@ func initializeExclusionPatterns(opt *Options) {
 	}
 }
 
+// initCacheFilter compiles the VfsCacheInclude/VfsCacheExclude glob
+// patterns and the VfsCacheMinSize/MaxSize/MinAge/MaxAge bounds into a
+// single *filter.Filter that ShouldCache can consult.
+func initCacheFilter(opt *Options) {
+	filterOpt := filter.DefaultOpt
+	// Only override filter.DefaultOpt's "off" sentinels when the user
+	// actually configured a bound: assigning the zero value here would
+	// mean e.g. VfsCacheMaxAge unset turns into "only cache files
+	// modified less than 0s ago", which excludes everything.
+	if opt.VfsCacheMinSize > 0 {
+		filterOpt.MinSize = opt.VfsCacheMinSize
+	}
+	if opt.VfsCacheMaxSize > 0 {
+		filterOpt.MaxSize = opt.VfsCacheMaxSize
+	}
+	if opt.VfsCacheMinAge > 0 {
+		filterOpt.MinAge = fs.Duration(opt.VfsCacheMinAge)
+	}
+	if opt.VfsCacheMaxAge > 0 {
+		filterOpt.MaxAge = fs.Duration(opt.VfsCacheMaxAge)
+	}
+	filterOpt.IncludeRule = opt.VfsCacheInclude
+	filterOpt.ExcludeRule = opt.VfsCacheExclude
+	f, err := filter.NewFilter(&filterOpt)
+	if err != nil {
+		fs.Errorf(nil, "Failed to create VFS cache filter: %v", err)
+		return
+	}
+	opt.cacheFilter = f
+}
+
+// ShouldCache reports whether the file at remote, with the given size
+// and modification time, should be stored in the VFS cache, as one
+// decision point for the cache layer, the upload path and the writeback
+// scheduler to share; nothing in this package calls it. It resolves remote's
+// VfsProfilesFile overrides via For, then checks only the VfsCache*
+// filter. VfsExcludeRegex (compiled from VfsUploadExclude) governs what
+// gets uploaded, a separate decision, and is intentionally not
+// consulted here.
+func (opt *Options) ShouldCache(remote string, size int64, modTime time.Time) bool {
+	effective := opt.For(remote)
+	if effective.cacheFilter == nil {
+		return true
+	}
+	return effective.cacheFilter.Include(remote, size, modTime, nil)
+}
+
+// initCacheEncryption validates the encryption key file and sets up the
+// cipher used by the cryptcache wrapper, if CacheEncrypt is set. Since
+// CacheEncrypt is an explicit request to never write plaintext to disk,
+// a setup failure here must abort startup rather than silently falling
+// back to an unencrypted cache.
+func initCacheEncryption(opt *Options) {
+	if !opt.CacheEncrypt {
+		return
+	}
+	c, err := cryptcache.New(opt.CacheEncryptKeyFile, opt.CacheEncryptCipher, int64(opt.ChunkSize))
+	if err != nil {
+		fs.Fatalf(nil, "VFS cache encryption was requested but could not be set up: %v", err)
+	}
+	opt.cacheCipher = c
+}
+
+// CacheCipher returns the cipher to use to encrypt the VFS disk cache,
+// or nil if CacheEncrypt is not set. A setup failure aborts Init, so
+// there is no nil result for a failed setup.
+func (opt *Options) CacheCipher() *cryptcache.Cipher {
+	return opt.cacheCipher
+}
+
+// initChunkController sets up opt.chunkController if ChunkAdaptive is
+// set.
+func initChunkController(opt *Options) {
+	if !opt.ChunkAdaptive {
+		return
+	}
+	opt.chunkController = NewChunkSizeController(opt)
+}
+
+// NextChunkSize returns the chunk size to use for the next chunked read
+// of remote, given the size of the previous chunk and how long it took.
+// It resolves remote's VfsProfilesFile overrides via For, then either
+// defers to the ChunkSizeController (if ChunkAdaptive is set) or falls
+// back to the legacy ChunkSizeLimit doubling. If metrics are enabled,
+// the chunk's read latency is recorded either way.
+func (opt *Options) NextChunkSize(remote string, prevSize int64, elapsed time.Duration) int64 {
+	effective := opt.For(remote)
+	if opt.metrics != nil && elapsed > 0 {
+		opt.metrics.ChunkReadLatency.Observe(elapsed.Seconds())
+	}
+	if effective.ChunkAdaptive && effective.chunkController != nil {
+		return effective.chunkController.Next(prevSize, elapsed)
+	}
+	// A limit < 1, or one not above ChunkSize, means "no growth",
+	// matching chunkedreader.New clamping chunkSizeLimit to chunkSize in
+	// that case, not unbounded doubling.
+	if effective.ChunkSizeLimit <= 0 || effective.ChunkSizeLimit <= effective.ChunkSize {
+		return int64(effective.ChunkSize)
+	}
+	limit := int64(effective.ChunkSizeLimit)
+	if prevSize > limit/2 {
+		return limit
+	}
+	return prevSize * 2
+}
+
 // Init the options, making sure everything is within range
 func (opt *Options) Init() {
 	// Mask the permissions with the umask
@@ -91,4 +227,9 @@ func (opt *Options) Init() {
 	// Make sure directories are returned as directories
 	opt.DirPerms |= os.ModeDir
 	initializeExclusionPatterns(opt)
+	initCacheFilter(opt)
+	loadProfiles(opt)
+	initCacheEncryption(opt)
+	initChunkController(opt)
+	initMetrics(opt)
 }
@@ -0,0 +1,161 @@
+package vfscommon
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"gopkg.in/yaml.v3"
+)
+
+// vfsCacheMode, vfsDuration and vfsSizeSuffix wrap their underlying
+// types with string-based UnmarshalYAML/UnmarshalJSON, since none of
+// CacheMode, time.Duration or fs.SizeSuffix parse a human-written
+// string like "full", "24h" or "512M" out of the box: YAML and JSON
+// would otherwise only accept their raw numeric encoding.
+type vfsCacheMode struct{ CacheMode }
+type vfsDuration struct{ time.Duration }
+type vfsSizeSuffix struct{ fs.SizeSuffix }
+
+func (m *vfsCacheMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return m.CacheMode.Set(s)
+}
+
+func (m *vfsCacheMode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return m.CacheMode.Set(s)
+}
+
+func (d *vfsDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d *vfsDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (s *vfsSizeSuffix) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	return s.SizeSuffix.Set(str)
+}
+
+func (s *vfsSizeSuffix) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	return s.SizeSuffix.Set(str)
+}
+
+// VfsProfile is a set of Options overrides applied to remotes or paths
+// whose name starts with Match, as loaded from a VfsProfilesFile. Only
+// the fields that are set (non-nil) are overridden; everything else is
+// inherited from the base Options.
+type VfsProfile struct {
+	Match          string         `yaml:"match" json:"match"`
+	CacheMode      *vfsCacheMode  `yaml:"cache_mode,omitempty" json:"cache_mode,omitempty"`
+	CacheMaxAge    *vfsDuration   `yaml:"cache_max_age,omitempty" json:"cache_max_age,omitempty"`
+	CacheMaxSize   *vfsSizeSuffix `yaml:"cache_max_size,omitempty" json:"cache_max_size,omitempty"`
+	ReadAhead      *vfsSizeSuffix `yaml:"read_ahead,omitempty" json:"read_ahead,omitempty"`
+	ChunkSize      *vfsSizeSuffix `yaml:"chunk_size,omitempty" json:"chunk_size,omitempty"`
+	ChunkSizeLimit *vfsSizeSuffix `yaml:"chunk_size_limit,omitempty" json:"chunk_size_limit,omitempty"`
+}
+
+// vfsProfilesFile is the top level shape of a VfsProfilesFile
+type vfsProfilesFile struct {
+	Profiles []VfsProfile `yaml:"profiles" json:"profiles"`
+}
+
+// loadProfiles reads and parses opt.VfsProfilesFile, if set, into
+// opt.profiles. Parse failures are logged and leave opt.profiles empty
+// rather than aborting Init.
+func loadProfiles(opt *Options) {
+	if opt.VfsProfilesFile == "" {
+		return
+	}
+	data, err := os.ReadFile(opt.VfsProfilesFile)
+	if err != nil {
+		fs.Errorf(nil, "Failed to read VFS profiles file %q: %v", opt.VfsProfilesFile, err)
+		return
+	}
+	var parsed vfsProfilesFile
+	if strings.HasSuffix(opt.VfsProfilesFile, ".json") {
+		err = json.Unmarshal(data, &parsed)
+	} else {
+		err = yaml.Unmarshal(data, &parsed)
+	}
+	if err != nil {
+		fs.Errorf(nil, "Failed to parse VFS profiles file %q: %v", opt.VfsProfilesFile, err)
+		return
+	}
+	opt.profiles = parsed.Profiles
+}
+
+// For returns the Options that apply to remote: a copy of opt with the
+// longest-matching VfsProfile's overrides applied on top. If no profile
+// matches, or none were loaded, opt itself is returned unchanged.
+func (opt *Options) For(remote string) *Options {
+	var best *VfsProfile
+	for i := range opt.profiles {
+		p := &opt.profiles[i]
+		if !strings.HasPrefix(remote, p.Match) {
+			continue
+		}
+		if best == nil || len(p.Match) > len(best.Match) {
+			best = p
+		}
+	}
+	if best == nil {
+		return opt
+	}
+	merged := *opt
+	if best.CacheMode != nil {
+		merged.CacheMode = best.CacheMode.CacheMode
+	}
+	if best.CacheMaxAge != nil {
+		merged.CacheMaxAge = best.CacheMaxAge.Duration
+	}
+	if best.CacheMaxSize != nil {
+		merged.CacheMaxSize = best.CacheMaxSize.SizeSuffix
+	}
+	if best.ReadAhead != nil {
+		merged.ReadAhead = best.ReadAhead.SizeSuffix
+	}
+	if best.ChunkSize != nil {
+		merged.ChunkSize = best.ChunkSize.SizeSuffix
+	}
+	if best.ChunkSizeLimit != nil {
+		merged.ChunkSizeLimit = best.ChunkSizeLimit.SizeSuffix
+	}
+	return &merged
+}
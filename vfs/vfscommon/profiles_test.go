@@ -0,0 +1,60 @@
+package vfscommon
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseProfilesYAML(t *testing.T) {
+	data := []byte(`
+profiles:
+  - match: "remote:slow/"
+    cache_mode: full
+    read_ahead: 512M
+    cache_max_age: 24h
+`)
+	var parsed vfsProfilesFile
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	if len(parsed.Profiles) != 1 {
+		t.Fatalf("got %d profiles, want 1", len(parsed.Profiles))
+	}
+	p := parsed.Profiles[0]
+	if p.Match != "remote:slow/" {
+		t.Fatalf("Match = %q", p.Match)
+	}
+	if p.CacheMode == nil || p.CacheMode.CacheMode != CacheModeFull {
+		t.Fatalf("CacheMode = %v, want %v", p.CacheMode, CacheModeFull)
+	}
+	if p.ReadAhead == nil || p.ReadAhead.SizeSuffix != 512*1024*1024 {
+		t.Fatalf("ReadAhead = %v", p.ReadAhead)
+	}
+	if p.CacheMaxAge == nil || p.CacheMaxAge.Duration != 24*time.Hour {
+		t.Fatalf("CacheMaxAge = %v", p.CacheMaxAge)
+	}
+}
+
+func TestOptionsForAppliesProfile(t *testing.T) {
+	opt := DefaultOpt
+	opt.profiles = []VfsProfile{
+		{
+			Match:     "remote:slow/",
+			CacheMode: &vfsCacheMode{CacheMode: CacheModeFull},
+			ReadAhead: &vfsSizeSuffix{SizeSuffix: 512 * 1024 * 1024},
+		},
+	}
+	effective := opt.For("remote:slow/big-file")
+	if effective.CacheMode != CacheModeFull {
+		t.Fatalf("CacheMode = %v, want %v", effective.CacheMode, CacheModeFull)
+	}
+	if effective.ReadAhead != 512*1024*1024 {
+		t.Fatalf("ReadAhead = %v", effective.ReadAhead)
+	}
+	unmatched := opt.For("remote:fast/big-file")
+	if unmatched != &opt {
+		t.Fatalf("For should return opt unchanged when no profile matches")
+	}
+}